@@ -0,0 +1,165 @@
+package neuralnet
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/gonum/blas/blas64"
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// Adam is an Optimizer implementing the Adam update rule: it
+// keeps per-variable moving averages of the gradient (the
+// first moment) and its square (the second moment), and uses
+// bias-corrected versions of both to compute each step.
+type Adam struct {
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+
+	vars []*autofunc.Variable
+	m    []linalg.Vector
+	v    []linalg.Vector
+	t    int
+}
+
+// NewAdam creates an Adam optimizer that will update vars,
+// using the defaults from the original Adam paper: Beta1=0.9,
+// Beta2=0.999, Epsilon=1e-8.
+func NewAdam(vars []*autofunc.Variable) *Adam {
+	return &Adam{
+		Beta1:   0.9,
+		Beta2:   0.999,
+		Epsilon: 1e-8,
+		vars:    vars,
+		m:       zeroVectors(vars),
+		v:       zeroVectors(vars),
+	}
+}
+
+// Step updates the tracked variables in place.
+func (a *Adam) Step(grad autofunc.Gradient, lr float64) {
+	a.t++
+	biasCorrection1 := 1 - math.Pow(a.Beta1, float64(a.t))
+	biasCorrection2 := 1 - math.Pow(a.Beta2, float64(a.t))
+
+	for i, v := range a.vars {
+		g := grad[v]
+		if g == nil {
+			continue
+		}
+		a.stepVar(i, v, g, lr, biasCorrection1, biasCorrection2)
+	}
+}
+
+func (a *Adam) stepVar(i int, variable *autofunc.Variable, g linalg.Vector, lr,
+	biasCorrection1, biasCorrection2 float64) {
+	m := a.m[i]
+	v := a.v[i]
+	update := make(linalg.Vector, len(g))
+	for j, gj := range g {
+		m[j] = a.Beta1*m[j] + (1-a.Beta1)*gj
+		v[j] = a.Beta2*v[j] + (1-a.Beta2)*gj*gj
+		mHat := m[j] / biasCorrection1
+		vHat := v[j] / biasCorrection2
+		update[j] = -lr * mHat / (math.Sqrt(vHat) + a.Epsilon)
+	}
+	dest := blas64.Vector{Inc: 1, Data: variable.Vector}
+	src := blas64.Vector{Inc: 1, Data: update}
+	blas64.Axpy(len(update), 1, src, dest)
+}
+
+type adamState struct {
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+	M       []linalg.Vector
+	V       []linalg.Vector
+	T       int
+}
+
+// Serialize encodes a's state. See Optimizer.Serialize.
+func (a *Adam) Serialize() ([]byte, error) {
+	return json.Marshal(&adamState{
+		Beta1:   a.Beta1,
+		Beta2:   a.Beta2,
+		Epsilon: a.Epsilon,
+		M:       a.m,
+		V:       a.v,
+		T:       a.t,
+	})
+}
+
+// DeserializeAdam decodes an Adam optimizer previously encoded
+// with Serialize, re-attaching it to vars.
+func DeserializeAdam(data []byte, vars []*autofunc.Variable) (*Adam, error) {
+	var s adamState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &Adam{
+		Beta1:   s.Beta1,
+		Beta2:   s.Beta2,
+		Epsilon: s.Epsilon,
+		vars:    vars,
+		m:       s.M,
+		v:       s.V,
+		t:       s.T,
+	}, nil
+}
+
+// AdamW is Adam with decoupled weight decay: instead of adding
+// L2 regularization into the gradient before it's averaged
+// (which Adam's adaptive scaling distorts), it shrinks each
+// variable directly by WeightDecay*lr every step.
+type AdamW struct {
+	*Adam
+	WeightDecay float64
+}
+
+// NewAdamW creates an AdamW optimizer that will update vars,
+// with the same Adam defaults as NewAdam and WeightDecay=0.01.
+func NewAdamW(vars []*autofunc.Variable) *AdamW {
+	return &AdamW{Adam: NewAdam(vars), WeightDecay: 0.01}
+}
+
+// Step updates the tracked variables in place.
+func (a *AdamW) Step(grad autofunc.Gradient, lr float64) {
+	decay := 1 - lr*a.WeightDecay
+	for _, variable := range a.vars {
+		if grad[variable] == nil {
+			continue
+		}
+		blas64.Scal(len(variable.Vector), decay, blas64.Vector{Inc: 1, Data: variable.Vector})
+	}
+	a.Adam.Step(grad, lr)
+}
+
+type adamWState struct {
+	Adam        json.RawMessage
+	WeightDecay float64
+}
+
+// Serialize encodes a's state. See Optimizer.Serialize.
+func (a *AdamW) Serialize() ([]byte, error) {
+	inner, err := a.Adam.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&adamWState{Adam: inner, WeightDecay: a.WeightDecay})
+}
+
+// DeserializeAdamW decodes an AdamW optimizer previously
+// encoded with Serialize, re-attaching it to vars.
+func DeserializeAdamW(data []byte, vars []*autofunc.Variable) (*AdamW, error) {
+	var s adamWState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	adam, err := DeserializeAdam(s.Adam, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &AdamW{Adam: adam, WeightDecay: s.WeightDecay}, nil
+}
@@ -0,0 +1,251 @@
+package neuralnet
+
+import (
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas64"
+	"github.com/unixpickle/autofunc"
+)
+
+// im2col unrolls the receptive fields of inTensor into the
+// columns of a (FilterWidth*FilterHeight*InputDepth) x
+// (OutputWidth*OutputHeight) matrix, so that a convolution
+// can be computed as a single matrix multiplication against
+// the layer's flattened filter matrix.
+func (c *ConvLayer) im2col(inTensor *Tensor3) *blas64.General {
+	outW := c.OutputWidth()
+	outH := c.OutputHeight()
+
+	if c.Padding == 0 && c.dilation() == 1 {
+		return im2colCrop(inTensor, c.FilterWidth, c.FilterHeight, c.InputDepth,
+			c.Stride, outW, outH)
+	}
+	return im2colPadded(inTensor, c.FilterWidth, c.FilterHeight, c.InputDepth,
+		c.Stride, c.Padding, c.dilation(), outW, outH)
+}
+
+// col2im is the inverse of im2col: it scatter-adds each
+// column of colGrad (a patchSize x OutputWidth*OutputHeight
+// matrix of gradients with respect to the unrolled patches)
+// back into a gradient tensor shaped like the layer's input.
+// col2im is only ever used to compute an input gradient, which
+// is consumed synchronously by the caller, so its result comes
+// from the pool; callers must Drop it once they're done with
+// its Data.
+func (c *ConvLayer) col2im(colGrad *blas64.General) *Tensor3 {
+	outW := c.OutputWidth()
+	outH := c.OutputHeight()
+
+	if c.Padding == 0 && c.dilation() == 1 {
+		return col2imCrop(colGrad, c.InputWidth, c.InputHeight, c.InputDepth,
+			c.FilterWidth, c.FilterHeight, c.Stride, outW, outH)
+	}
+	return col2imPadded(colGrad, c.InputWidth, c.InputHeight, c.InputDepth,
+		c.FilterWidth, c.FilterHeight, c.Stride, c.Padding, c.dilation(), outW, outH, true)
+}
+
+// im2colCrop is the fast path for valid-mode, undilated
+// convolutions: it reuses Tensor3.Crop to copy each receptive
+// field directly, rather than bounds-checking every tap. Its
+// result is always a purely internal GEMM operand, so it comes
+// from the pool; callers must call dropMatrix on it once
+// they're done with it.
+func im2colCrop(inTensor *Tensor3, filterW, filterH, depth, stride, outW, outH int) *blas64.General {
+	patchSize := filterW * filterH * depth
+	col := getPooledMatrix(patchSize, outW*outH)
+
+	cropped := getPooledTensor3(filterW, filterH, depth)
+	defer cropped.Drop()
+	for y := 0; y < outH; y++ {
+		inputY := y * stride
+		for x := 0; x < outW; x++ {
+			inputX := x * stride
+			inTensor.Crop(inputX, inputY, cropped)
+			colIdx := y*outW + x
+			for row := 0; row < patchSize; row++ {
+				col.Data[row*col.Stride+colIdx] = cropped.Data[row]
+			}
+		}
+	}
+
+	return col
+}
+
+// col2imCrop is the col2im counterpart of im2colCrop, for
+// valid-mode, undilated convolutions. Like col2im, its result
+// comes from the pool; callers must Drop it once they're done
+// with its Data.
+func col2imCrop(colGrad *blas64.General, inW, inH, depth, filterW, filterH, stride,
+	outW, outH int) *Tensor3 {
+	inputGrad := getPooledTensor3(inW, inH, depth)
+	patch := getPooledTensor3(filterW, filterH, depth)
+	defer patch.Drop()
+	patchSize := filterW * filterH * depth
+
+	for y := 0; y < outH; y++ {
+		inputY := y * stride
+		for x := 0; x < outW; x++ {
+			inputX := x * stride
+			colIdx := y*outW + x
+			for row := 0; row < patchSize; row++ {
+				patch.Data[row] = colGrad.Data[row*colGrad.Stride+colIdx]
+			}
+			inputGrad.MulAdd(inputX, inputY, patch, 1)
+		}
+	}
+
+	return inputGrad
+}
+
+// im2colPadded is the general im2col path: it supports zero
+// padding and dilated filter taps by reading each tap through
+// a bounds-checked Get instead of Tensor3.Crop. Like
+// im2colCrop, its result is always a purely internal GEMM
+// operand, so it comes from the pool; callers must call
+// dropMatrix on it once they're done with it.
+func im2colPadded(inTensor *Tensor3, filterW, filterH, depth, stride, padding, dilation,
+	outW, outH int) *blas64.General {
+	patchSize := filterW * filterH * depth
+	col := getPooledMatrix(patchSize, outW*outH)
+
+	for oy := 0; oy < outH; oy++ {
+		for ox := 0; ox < outW; ox++ {
+			colIdx := oy*outW + ox
+			row := 0
+			for fy := 0; fy < filterH; fy++ {
+				iy := oy*stride - padding + fy*dilation
+				for fx := 0; fx < filterW; fx++ {
+					ix := ox*stride - padding + fx*dilation
+					inBounds := ix >= 0 && ix < inTensor.Width && iy >= 0 && iy < inTensor.Height
+					for z := 0; z < depth; z++ {
+						var v float64
+						if inBounds {
+							v = inTensor.Get(ix, iy, z)
+						}
+						col.Data[row*col.Stride+colIdx] = v
+						row++
+					}
+				}
+			}
+		}
+	}
+
+	return col
+}
+
+// col2imPadded is the col2im counterpart of im2colPadded:
+// out-of-bounds taps (from padding) are simply dropped instead
+// of scattered. Unlike col2im/col2imCrop, col2imPadded is also
+// called directly by ConvTransposeLayer's forward pass, where
+// the result becomes the layer's retained output rather than a
+// transient gradient, so pooled selects whether the result
+// comes from the pool (callers must Drop it) or is a plain,
+// unpooled Tensor3 (safe to retain indefinitely).
+func col2imPadded(colGrad *blas64.General, inW, inH, depth, filterW, filterH, stride,
+	padding, dilation, outW, outH int, pooled bool) *Tensor3 {
+	var inputGrad *Tensor3
+	if pooled {
+		inputGrad = getPooledTensor3(inW, inH, depth)
+	} else {
+		inputGrad = NewTensor3(inW, inH, depth)
+	}
+
+	for oy := 0; oy < outH; oy++ {
+		for ox := 0; ox < outW; ox++ {
+			colIdx := oy*outW + ox
+			row := 0
+			for fy := 0; fy < filterH; fy++ {
+				iy := oy*stride - padding + fy*dilation
+				for fx := 0; fx < filterW; fx++ {
+					ix := ox*stride - padding + fx*dilation
+					inBounds := ix >= 0 && ix < inW && iy >= 0 && iy < inH
+					for z := 0; z < depth; z++ {
+						if inBounds {
+							v := colGrad.Data[row*colGrad.Stride+colIdx]
+							inputGrad.Set(ix, iy, z, inputGrad.Get(ix, iy, z)+v)
+						}
+						row++
+					}
+				}
+			}
+		}
+	}
+
+	return inputGrad
+}
+
+// filterMatrix flattens the layer's filters into a
+// FilterCount x (FilterWidth*FilterHeight*InputDepth) matrix,
+// one filter per row, suitable for use as the left-hand side
+// of the im2col GEMM.
+func (c *ConvLayer) filterMatrix() *blas64.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.InputDepth
+	m := &blas64.General{
+		Rows:   c.FilterCount,
+		Cols:   patchSize,
+		Stride: patchSize,
+		Data:   make([]float64, c.FilterCount*patchSize),
+	}
+	for i, filter := range c.Filters {
+		copy(m.Data[i*patchSize:(i+1)*patchSize], filter.Data)
+	}
+	return m
+}
+
+// filterMatrixR is the filterMatrix counterpart for an
+// autofunc.RVector: it flattens the R-derivative of each
+// filter into the same shape filterMatrix produces, treating
+// filters with no entry in v as having a zero R-derivative.
+func (c *ConvLayer) filterMatrixR(v autofunc.RVector) *blas64.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.InputDepth
+	m := &blas64.General{
+		Rows:   c.FilterCount,
+		Cols:   patchSize,
+		Stride: patchSize,
+		Data:   make([]float64, c.FilterCount*patchSize),
+	}
+	for i, filterVar := range c.FilterVars {
+		if data := v[filterVar]; data != nil {
+			copy(m.Data[i*patchSize:(i+1)*patchSize], data)
+		}
+	}
+	return m
+}
+
+// upstreamMatrix reads outTensor out through Get (rather than
+// aliasing its Data directly, since Tensor3's layout isn't
+// part of its contract) into a FilterCount x (outW*outH)
+// matrix suitable for use as a GEMM operand. Its result is
+// always a purely internal GEMM operand, so it comes from the
+// pool; callers must call dropMatrix on it once they're done
+// with it.
+func (c *ConvLayer) upstreamMatrix(outTensor *Tensor3) *blas64.General {
+	outW, outH := outTensor.Width, outTensor.Height
+	m := getPooledMatrix(c.FilterCount, outW*outH)
+	for z := 0; z < c.FilterCount; z++ {
+		for idx := 0; idx < outW*outH; idx++ {
+			x := idx % outW
+			y := idx / outW
+			m.Data[z*m.Stride+idx] = outTensor.Get(x, y, z)
+		}
+	}
+	return m
+}
+
+// gemmNN is a thin wrapper around blas64.Gemm using the
+// no-transpose/no-transpose case, which is all the im2col
+// convolution path needs.
+func gemmNN(alpha float64, a, b *blas64.General, beta float64, c *blas64.General) {
+	blas64.Gemm(blas.NoTrans, blas.NoTrans, alpha, *a, *b, beta, *c)
+}
+
+// gemmTN multiplies a^T by b, used for the input-gradient
+// GEMM in the backward pass.
+func gemmTN(alpha float64, a, b *blas64.General, beta float64, c *blas64.General) {
+	blas64.Gemm(blas.Trans, blas.NoTrans, alpha, *a, *b, beta, *c)
+}
+
+// gemmNT multiplies a by b^T, used for the filter-gradient
+// GEMM in the backward pass.
+func gemmNT(alpha float64, a, b *blas64.General, beta float64, c *blas64.General) {
+	blas64.Gemm(blas.NoTrans, blas.Trans, alpha, *a, *b, beta, *c)
+}
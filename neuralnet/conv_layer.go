@@ -17,6 +17,18 @@ type ConvLayer struct {
 	FilterHeight int
 	Stride       int
 
+	// Padding is the number of zero-valued pixels implicitly
+	// added to each side of the input before convolving. It
+	// defaults to 0 (valid-mode convolution).
+	Padding int
+
+	// Dilation spaces out the filter taps: a dilation of d
+	// places d-1 zero-valued gaps between adjacent taps. A
+	// zero value is treated the same as 1 (no dilation), so
+	// that layers serialized before this field existed still
+	// behave as valid-mode, undilated convolutions.
+	Dilation int
+
 	InputWidth  int
 	InputHeight int
 	InputDepth  int
@@ -40,9 +52,20 @@ func DeserializeConvLayer(data []byte) (*ConvLayer, error) {
 	return &c, nil
 }
 
+// dilation returns the layer's effective dilation, treating
+// a zero value (e.g. from a layer serialized before Dilation
+// existed) as 1, meaning no dilation.
+func (c *ConvLayer) dilation() int {
+	if c.Dilation == 0 {
+		return 1
+	}
+	return c.Dilation
+}
+
 // OutputWidth computes the width of the output tensor.
 func (c *ConvLayer) OutputWidth() int {
-	w := 1 + (c.InputWidth-c.FilterWidth)/c.Stride
+	effFilterWidth := c.dilation()*(c.FilterWidth-1) + 1
+	w := 1 + (c.InputWidth+2*c.Padding-effFilterWidth)/c.Stride
 	if w < 0 {
 		return 0
 	}
@@ -51,7 +74,8 @@ func (c *ConvLayer) OutputWidth() int {
 
 // OutputHeight computes the height of the output tensor.
 func (c *ConvLayer) OutputHeight() int {
-	h := 1 + (c.InputHeight-c.FilterHeight)/c.Stride
+	effFilterHeight := c.dilation()*(c.FilterHeight-1) + 1
+	h := 1 + (c.InputHeight+2*c.Padding-effFilterHeight)/c.Stride
 	if h < 0 {
 		return 0
 	}
@@ -134,7 +158,44 @@ func (c *ConvLayer) SerializerType() string {
 	return serializerTypeConvLayer
 }
 
+// convolve computes the layer's output using an im2col
+// transformation followed by a single GEMM against the
+// flattened filter matrix, rather than a per-output-pixel
+// dot-product loop. See convolveLoop for the reference
+// implementation this is benchmarked against.
 func (c *ConvLayer) convolve(input linalg.Vector) *Tensor3 {
+	inTensor := c.inputToTensor(input)
+	outW := c.OutputWidth()
+	outH := c.OutputHeight()
+	outTensor := NewTensor3(outW, outH, c.OutputDepth())
+
+	col := c.im2col(inTensor)
+	defer dropMatrix(col)
+	filterMat := c.filterMatrix()
+	out := &blas64.General{
+		Rows:   c.FilterCount,
+		Cols:   outW * outH,
+		Stride: outW * outH,
+		Data:   make([]float64, c.FilterCount*outW*outH),
+	}
+	gemmNN(1, filterMat, col, 0, out)
+
+	for z := 0; z < c.FilterCount; z++ {
+		bias := c.Biases.Vector[z]
+		for idx := 0; idx < outW*outH; idx++ {
+			x := idx % outW
+			y := idx / outW
+			outTensor.Set(x, y, z, out.Data[z*out.Stride+idx]+bias)
+		}
+	}
+
+	return outTensor
+}
+
+// convolveLoop is the original, unoptimized implementation
+// of convolve, kept around so BenchmarkConvLayerConvolveLoop
+// can be compared against the im2col/GEMM path in convolve.
+func (c *ConvLayer) convolveLoop(input linalg.Vector) *Tensor3 {
 	inTensor := c.inputToTensor(input)
 	croppedInput := NewTensor3(c.FilterWidth, c.FilterHeight, c.InputDepth)
 	outTensor := NewTensor3(c.OutputWidth(), c.OutputHeight(), c.OutputDepth())
@@ -162,48 +223,46 @@ func (c *ConvLayer) convolve(input linalg.Vector) *Tensor3 {
 	return outTensor
 }
 
+// convolveR computes the R-derivative of convolve's output
+// with respect to the directional derivative v, via the
+// product rule applied to the same im2col/GEMM decomposition
+// convolve uses: d(filterMat*col)/dR = filterMatR*col +
+// filterMat*colR. This (unlike the old Crop-based loop it
+// replaced) goes through im2col/col2im, so it respects
+// Padding and Dilation the same way convolve does.
 func (c *ConvLayer) convolveR(v autofunc.RVector, input, inputR linalg.Vector) *Tensor3 {
 	inTensor := c.inputToTensor(input)
 	inTensorR := c.inputToTensor(inputR)
-	croppedInput := NewTensor3(c.FilterWidth, c.FilterHeight, c.InputDepth)
-	croppedInputR := NewTensor3(c.FilterWidth, c.FilterHeight, c.InputDepth)
-	outTensor := NewTensor3(c.OutputWidth(), c.OutputHeight(), c.OutputDepth())
+	outW := c.OutputWidth()
+	outH := c.OutputHeight()
+	outTensor := NewTensor3(outW, outH, c.OutputDepth())
+
+	col := c.im2col(inTensor)
+	defer dropMatrix(col)
+	colR := c.im2col(inTensorR)
+	defer dropMatrix(colR)
+	filterMat := c.filterMatrix()
+	filterMatR := c.filterMatrixR(v)
+
+	out := &blas64.General{
+		Rows:   c.FilterCount,
+		Cols:   outW * outH,
+		Stride: outW * outH,
+		Data:   make([]float64, c.FilterCount*outW*outH),
+	}
+	gemmNN(1, filterMatR, col, 0, out)
+	gemmNN(1, filterMat, colR, 1, out)
 
-	filtersR := c.filtersR(v)
 	biasR := v[c.Biases]
-
-	for y := 0; y < outTensor.Height; y++ {
-		inputY := y * c.Stride
-		for x := 0; x < outTensor.Width; x++ {
-			inputX := x * c.Stride
-			inTensor.Crop(inputX, inputY, croppedInput)
-			inTensorR.Crop(inputX, inputY, croppedInputR)
-			croppedVec := blas64.Vector{
-				Inc:  1,
-				Data: croppedInput.Data,
-			}
-			croppedVecR := blas64.Vector{
-				Inc:  1,
-				Data: croppedInputR.Data,
-			}
-			for z, filter := range c.Filters {
-				filterVec := blas64.Vector{
-					Inc:  1,
-					Data: filter.Data,
-				}
-				convolution := blas64.Dot(len(filter.Data), filterVec, croppedVecR)
-				if rfilter := filtersR[z]; rfilter != nil {
-					filterVecR := blas64.Vector{
-						Inc:  1,
-						Data: rfilter.Data,
-					}
-					convolution += blas64.Dot(len(rfilter.Data), filterVecR, croppedVec)
-				}
-				if biasR != nil {
-					convolution += biasR[z]
-				}
-				outTensor.Set(x, y, z, convolution)
-			}
+	for z := 0; z < c.FilterCount; z++ {
+		var bias float64
+		if biasR != nil {
+			bias = biasR[z]
+		}
+		for idx := 0; idx < outW*outH; idx++ {
+			x := idx % outW
+			y := idx / outW
+			outTensor.Set(x, y, z, out.Data[z*out.Stride+idx]+bias)
 		}
 	}
 
@@ -232,19 +291,6 @@ func (c *ConvLayer) gradsFromMap(m map[*autofunc.Variable]linalg.Vector) (bias l
 	return
 }
 
-func (c *ConvLayer) filtersR(v autofunc.RVector) []*Tensor3 {
-	var filtersR []*Tensor3
-	for _, filterVar := range c.FilterVars {
-		data := v[filterVar]
-		if data == nil {
-			filtersR = append(filtersR, nil)
-		} else {
-			filtersR = append(filtersR, c.filterToTensor(data))
-		}
-	}
-	return filtersR
-}
-
 func (c *ConvLayer) inputToTensor(in linalg.Vector) *Tensor3 {
 	return &Tensor3{
 		Width:  c.InputWidth,
@@ -297,72 +343,77 @@ func (c *convLayerResult) Constant(g autofunc.Gradient) bool {
 	return true
 }
 
+// PropagateGradient back-propagates through the convolution
+// using the GEMM counterparts of the forward im2col pass:
+// filter gradients are one GEMM of upstream against the
+// im2col matrix's transpose, and input gradients are a
+// col2im of the filter matrix's transpose against upstream.
 func (c *convLayerResult) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
-	inputTensor := c.Layer.inputToTensor(c.Input.Output())
-	downstreamTensor := c.Layer.outputToTensor(upstream)
-
-	biasGrad, filterGrads := c.Layer.gradsFromMap(grad)
+	layer := c.Layer
+	outW, outH := c.OutputTensor.Width, c.OutputTensor.Height
+	patchSize := layer.FilterWidth * layer.FilterHeight * layer.InputDepth
+
+	// Tensor3's Data layout isn't part of its contract, so the
+	// upstream gradient is read out through Get (via
+	// upstreamMatrix) rather than aliased directly into the
+	// GEMM operand.
+	downstreamTensor := layer.outputToTensor(upstream)
+	upstreamMat := layer.upstreamMatrix(downstreamTensor)
+	defer dropMatrix(upstreamMat)
+
+	biasGrad, filterGrads := layer.gradsFromMap(grad)
+
+	if biasGrad != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			row := upstreamMat.Data[z*upstreamMat.Stride : (z+1)*upstreamMat.Stride]
+			for _, v := range row {
+				biasGrad[z] += v
+			}
+		}
+	}
 
-	var inputGrad *Tensor3
-	if !c.Input.Constant(grad) {
-		inputGrad = NewTensor3(c.Layer.InputWidth, c.Layer.InputHeight,
-			c.Layer.InputDepth)
+	if anyFilterGradNonNil(filterGrads) {
+		inputTensor := layer.inputToTensor(c.Input.Output())
+		col := layer.im2col(inputTensor)
+		filterGradMat := getPooledMatrix(layer.FilterCount, patchSize)
+		gemmNT(1, upstreamMat, col, 0, filterGradMat)
+		dropMatrix(col)
+		applyFilterGradMat(filterGradMat, filterGrads, patchSize)
+		dropMatrix(filterGradMat)
 	}
 
-	var tempInputGrad *Tensor3
-	if inputGrad != nil {
-		tempInputGrad = NewTensor3(c.Layer.FilterWidth, c.Layer.FilterHeight,
-			c.Layer.InputDepth)
+	if !c.Input.Constant(grad) {
+		filterMat := layer.filterMatrix()
+		colGrad := getPooledMatrix(patchSize, outW*outH)
+		gemmTN(1, filterMat, upstreamMat, 0, colGrad)
+		inputGrad := layer.col2im(colGrad)
+		dropMatrix(colGrad)
+		c.Input.PropagateGradient(inputGrad.Data, grad)
+		inputGrad.Drop()
 	}
-	croppedInput := NewTensor3(c.Layer.FilterWidth, c.Layer.FilterHeight,
-		c.Layer.InputDepth)
+}
 
-	for y := 0; y < c.OutputTensor.Height; y++ {
-		inputY := y * c.Layer.Stride
-		for x := 0; x < c.OutputTensor.Width; x++ {
-			inputX := x * c.Layer.Stride
-			if tempInputGrad != nil {
-				for i := range tempInputGrad.Data {
-					tempInputGrad.Data[i] = 0
-				}
-			}
-			inputTensor.Crop(inputX, inputY, croppedInput)
-			for z, filter := range c.Layer.Filters {
-				sumPartial := downstreamTensor.Get(x, y, z)
-				if filterGrad := filterGrads[z]; filterGrad != nil {
-					inTens := blas64.Vector{
-						Inc:  1,
-						Data: croppedInput.Data,
-					}
-					dest := blas64.Vector{
-						Inc:  1,
-						Data: filterGrad.Data,
-					}
-					blas64.Axpy(len(dest.Data), sumPartial, inTens, dest)
-				}
-				if biasGrad != nil {
-					biasGrad[z] += sumPartial
-				}
-				if inputGrad != nil {
-					temp := blas64.Vector{
-						Inc:  1,
-						Data: tempInputGrad.Data,
-					}
-					filterVec := blas64.Vector{
-						Inc:  1,
-						Data: filter.Data,
-					}
-					blas64.Axpy(len(temp.Data), sumPartial, filterVec, temp)
-				}
-			}
-			if tempInputGrad != nil {
-				inputGrad.MulAdd(inputX, inputY, tempInputGrad, 1)
-			}
+func anyFilterGradNonNil(filterGrads []*Tensor3) bool {
+	for _, f := range filterGrads {
+		if f != nil {
+			return true
 		}
 	}
+	return false
+}
 
-	if inputGrad != nil {
-		c.Input.PropagateGradient(inputGrad.Data, grad)
+// applyFilterGradMat adds the rows of filterGradMat (one row
+// per filter) into the corresponding entries of filterGrads,
+// skipping filters whose gradient isn't being tracked.
+func applyFilterGradMat(filterGradMat *blas64.General, filterGrads []*Tensor3, patchSize int) {
+	for z, filterGrad := range filterGrads {
+		if filterGrad == nil {
+			continue
+		}
+		row := filterGradMat.Data[z*filterGradMat.Stride : z*filterGradMat.Stride+patchSize]
+		dest := blas64.Vector{Inc: 1, Data: filterGrad.Data}
+		src := blas64.Vector{Inc: 1, Data: row}
+		blas64.Axpy(patchSize, 1, src, dest)
 	}
 }
 
@@ -404,58 +455,87 @@ func (c *convLayerRResult) Constant(rg autofunc.RGradient, g autofunc.Gradient)
 	return true
 }
 
+// PropagateRGradient is the R-derivative counterpart of
+// PropagateGradient, differentiating each of its three GEMMs
+// (bias, filter, input) via the product rule. Like
+// PropagateGradient (and unlike the Crop-based loop this
+// replaced), it goes through im2col/col2im, so it respects
+// Padding and Dilation.
 func (c *convLayerRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
 	rgrad autofunc.RGradient, grad autofunc.Gradient) {
-	inputTensor := c.Layer.inputToTensor(c.Input.Output())
-	inputTensorR := c.Layer.inputToTensor(c.Input.ROutput())
-	downstreamTensor := c.Layer.outputToTensor(upstream)
-	downstreamTensorR := c.Layer.outputToTensor(upstreamR)
+	layer := c.Layer
+	outW, outH := c.OutputTensor.Width, c.OutputTensor.Height
+	patchSize := layer.FilterWidth * layer.FilterHeight * layer.InputDepth
+
+	downstreamTensor := layer.outputToTensor(upstream)
+	downstreamTensorR := layer.outputToTensor(upstreamR)
+	upstreamMat := layer.upstreamMatrix(downstreamTensor)
+	defer dropMatrix(upstreamMat)
+	upstreamMatR := layer.upstreamMatrix(downstreamTensorR)
+	defer dropMatrix(upstreamMatR)
+
+	biasGrad, filterGrads := layer.gradsFromMap(grad)
+	biasGradR, filterGradsR := layer.gradsFromMap(rgrad)
+
+	if biasGrad != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			row := upstreamMat.Data[z*upstreamMat.Stride : (z+1)*upstreamMat.Stride]
+			for _, v := range row {
+				biasGrad[z] += v
+			}
+		}
+	}
+	if biasGradR != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			row := upstreamMatR.Data[z*upstreamMatR.Stride : (z+1)*upstreamMatR.Stride]
+			for _, v := range row {
+				biasGradR[z] += v
+			}
+		}
+	}
 
-	biasGrad, filterGrads := c.Layer.gradsFromMap(grad)
-	biasGradR, filterGradsR := c.Layer.gradsFromMap(rgrad)
+	needFilterGrad := anyFilterGradNonNil(filterGrads)
+	needFilterGradR := anyFilterGradNonNil(filterGradsR)
+	if needFilterGrad || needFilterGradR {
+		inputTensor := layer.inputToTensor(c.Input.Output())
+		col := layer.im2col(inputTensor)
 
-	var inputGrad *Tensor3
-	var inputGradR *Tensor3
+		if needFilterGrad {
+			filterGradMat := getPooledMatrix(layer.FilterCount, patchSize)
+			gemmNT(1, upstreamMat, col, 0, filterGradMat)
+			applyFilterGradMat(filterGradMat, filterGrads, patchSize)
+			dropMatrix(filterGradMat)
+		}
+		if needFilterGradR {
+			inputTensorR := layer.inputToTensor(c.Input.ROutput())
+			colR := layer.im2col(inputTensorR)
+			filterGradMatR := getPooledMatrix(layer.FilterCount, patchSize)
+			gemmNT(1, upstreamMatR, col, 0, filterGradMatR)
+			gemmNT(1, upstreamMat, colR, 1, filterGradMatR)
+			applyFilterGradMat(filterGradMatR, filterGradsR, patchSize)
+			dropMatrix(filterGradMatR)
+			dropMatrix(colR)
+		}
+		dropMatrix(col)
+	}
 
 	if !c.Input.Constant(rgrad, grad) {
-		inputGrad = NewTensor3(c.Layer.InputWidth, c.Layer.InputHeight, c.Layer.InputDepth)
-		inputGradR = NewTensor3(c.Layer.InputWidth, c.Layer.InputHeight, c.Layer.InputDepth)
-	}
+		filterMat := layer.filterMatrix()
+		filterMatR := layer.filterMatrixR(c.RV)
 
-	filtersR := c.Layer.filtersR(c.RV)
+		colGrad := getPooledMatrix(patchSize, outW*outH)
+		gemmTN(1, filterMat, upstreamMat, 0, colGrad)
+		inputGrad := layer.col2im(colGrad)
+		dropMatrix(colGrad)
 
-	for y := 0; y < c.OutputTensor.Height; y++ {
-		inputY := y * c.Layer.Stride
-		for x := 0; x < c.OutputTensor.Width; x++ {
-			inputX := x * c.Layer.Stride
-			for z, filter := range c.Layer.Filters {
-				sumPartial := downstreamTensor.Get(x, y, z)
-				sumPartialR := downstreamTensorR.Get(x, y, z)
-				if filterGrad := filterGrads[z]; filterGrad != nil {
-					filterGrad.MulAdd(-inputX, -inputY, inputTensor, sumPartial)
-				}
-				if filterGradR := filterGradsR[z]; filterGradR != nil {
-					filterGradR.MulAdd(-inputX, -inputY, inputTensor, sumPartialR)
-					filterGradR.MulAdd(-inputX, -inputY, inputTensorR, sumPartial)
-				}
-				if biasGrad != nil {
-					biasGrad[z] += sumPartial
-				}
-				if biasGradR != nil {
-					biasGradR[z] += sumPartialR
-				}
-				if inputGrad != nil {
-					inputGrad.MulAdd(inputX, inputY, filter, sumPartial)
-					inputGradR.MulAdd(inputX, inputY, filter, sumPartialR)
-					if rfilter := filtersR[z]; rfilter != nil {
-						inputGradR.MulAdd(inputX, inputY, rfilter, sumPartial)
-					}
-				}
-			}
-		}
-	}
+		colGradR := getPooledMatrix(patchSize, outW*outH)
+		gemmTN(1, filterMatR, upstreamMat, 0, colGradR)
+		gemmTN(1, filterMat, upstreamMatR, 1, colGradR)
+		inputGradR := layer.col2im(colGradR)
+		dropMatrix(colGradR)
 
-	if inputGrad != nil {
 		c.Input.PropagateRGradient(inputGrad.Data, inputGradR.Data, rgrad, grad)
+		inputGrad.Drop()
+		inputGradR.Drop()
 	}
 }
@@ -0,0 +1,35 @@
+package neuralnet
+
+import "testing"
+
+func benchmarkConvLayer() *ConvLayer {
+	layer := &ConvLayer{
+		FilterCount:  32,
+		FilterWidth:  5,
+		FilterHeight: 5,
+		Stride:       1,
+		InputWidth:   64,
+		InputHeight:  64,
+		InputDepth:   16,
+	}
+	layer.Randomize()
+	return layer
+}
+
+func BenchmarkConvLayerConvolveIm2Col(b *testing.B) {
+	layer := benchmarkConvLayer()
+	input := make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		layer.convolve(input)
+	}
+}
+
+func BenchmarkConvLayerConvolveLoop(b *testing.B) {
+	layer := benchmarkConvLayer()
+	input := make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		layer.convolveLoop(input)
+	}
+}
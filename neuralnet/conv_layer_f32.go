@@ -0,0 +1,223 @@
+package neuralnet
+
+import (
+	"encoding/json"
+
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas32"
+	"github.com/unixpickle/autofunc"
+)
+
+// A ConvLayerF32 is a float32 counterpart of ConvLayer. It
+// shares ConvLayer's im2col/GEMM forward pass (using blas32 in
+// place of blas64) but is inference-only: it has no
+// autofunc.Variable parameters and cannot be used with
+// autofunc.Gradient, since this package's reverse-mode
+// differentiation is float64-only. Train and gradient-check a
+// ConvLayer as usual, then call ConvLayer.ToFloat32 to get a
+// ConvLayerF32 for inference.
+//
+// Expect roughly 1e-3 relative error between a ConvLayerF32's
+// output and the ConvLayer it was converted from, due to
+// float32's reduced mantissa.
+type ConvLayerF32 struct {
+	FilterCount  int
+	FilterWidth  int
+	FilterHeight int
+	Stride       int
+	Padding      int
+	Dilation     int
+
+	InputWidth  int
+	InputHeight int
+	InputDepth  int
+
+	Filters []*Tensor3F32
+	Biases  []float32
+}
+
+func DeserializeConvLayerF32(data []byte) (*ConvLayerF32, error) {
+	var c ConvLayerF32
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ToFloat32 converts c into an inference-only ConvLayerF32
+// with the same shape and weights, rounded to float32.
+func (c *ConvLayer) ToFloat32() *ConvLayerF32 {
+	res := &ConvLayerF32{
+		FilterCount:  c.FilterCount,
+		FilterWidth:  c.FilterWidth,
+		FilterHeight: c.FilterHeight,
+		Stride:       c.Stride,
+		Padding:      c.Padding,
+		Dilation:     c.Dilation,
+		InputWidth:   c.InputWidth,
+		InputHeight:  c.InputHeight,
+		InputDepth:   c.InputDepth,
+		Biases:       make([]float32, len(c.Biases.Vector)),
+	}
+	for i, x := range c.Biases.Vector {
+		res.Biases[i] = float32(x)
+	}
+	for _, f := range c.Filters {
+		res.Filters = append(res.Filters, Tensor3ToF32(f))
+	}
+	return res
+}
+
+// ToFloat64 converts c back into a trainable ConvLayer with
+// the same shape and weights, widened to float64.
+func (c *ConvLayerF32) ToFloat64() *ConvLayer {
+	res := &ConvLayer{
+		FilterCount:  c.FilterCount,
+		FilterWidth:  c.FilterWidth,
+		FilterHeight: c.FilterHeight,
+		Stride:       c.Stride,
+		Padding:      c.Padding,
+		Dilation:     c.Dilation,
+		InputWidth:   c.InputWidth,
+		InputHeight:  c.InputHeight,
+		InputDepth:   c.InputDepth,
+	}
+	biasVec := make([]float64, len(c.Biases))
+	for i, x := range c.Biases {
+		biasVec[i] = float64(x)
+	}
+	res.Biases = &autofunc.Variable{Vector: biasVec}
+	for _, f := range c.Filters {
+		filter := Tensor3FromF32(f)
+		res.Filters = append(res.Filters, filter)
+		res.FilterVars = append(res.FilterVars, &autofunc.Variable{Vector: filter.Data})
+	}
+	return res
+}
+
+func (c *ConvLayerF32) dilation() int {
+	if c.Dilation == 0 {
+		return 1
+	}
+	return c.Dilation
+}
+
+// OutputWidth computes the width of the output tensor.
+func (c *ConvLayerF32) OutputWidth() int {
+	effFilterWidth := c.dilation()*(c.FilterWidth-1) + 1
+	w := 1 + (c.InputWidth+2*c.Padding-effFilterWidth)/c.Stride
+	if w < 0 {
+		return 0
+	}
+	return w
+}
+
+// OutputHeight computes the height of the output tensor.
+func (c *ConvLayerF32) OutputHeight() int {
+	effFilterHeight := c.dilation()*(c.FilterHeight-1) + 1
+	h := 1 + (c.InputHeight+2*c.Padding-effFilterHeight)/c.Stride
+	if h < 0 {
+		return 0
+	}
+	return h
+}
+
+// OutputDepth returns the depth of the output tensor.
+func (c *ConvLayerF32) OutputDepth() int {
+	return c.FilterCount
+}
+
+// Apply computes the convolution on input, which must be
+// InputWidth*InputHeight*InputDepth float32s, and returns
+// OutputWidth*OutputHeight*OutputDepth float32s.
+func (c *ConvLayerF32) Apply(input []float32) []float32 {
+	inTensor := &Tensor3F32{Width: c.InputWidth, Height: c.InputHeight,
+		Depth: c.InputDepth, Data: input}
+	outW, outH := c.OutputWidth(), c.OutputHeight()
+
+	col := c.im2col(inTensor, outW, outH)
+	filterMat := c.filterMatrix()
+	out := &blas32.General{
+		Rows:   c.FilterCount,
+		Cols:   outW * outH,
+		Stride: outW * outH,
+		Data:   make([]float32, c.FilterCount*outW*outH),
+	}
+	blas32.Gemm(blas.NoTrans, blas.NoTrans, 1, *filterMat, *col, 0, *out)
+
+	outTensor := NewTensor3F32(outW, outH, c.FilterCount)
+	for z := 0; z < c.FilterCount; z++ {
+		bias := c.Biases[z]
+		for idx := 0; idx < outW*outH; idx++ {
+			x := idx % outW
+			y := idx / outW
+			outTensor.Set(x, y, z, out.Data[z*out.Stride+idx]+bias)
+		}
+	}
+
+	return outTensor.Data
+}
+
+func (c *ConvLayerF32) im2col(inTensor *Tensor3F32, outW, outH int) *blas32.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.InputDepth
+	col := &blas32.General{
+		Rows:   patchSize,
+		Cols:   outW * outH,
+		Stride: outW * outH,
+		Data:   make([]float32, patchSize*outW*outH),
+	}
+
+	if c.Padding == 0 && c.dilation() == 1 {
+		cropped := NewTensor3F32(c.FilterWidth, c.FilterHeight, c.InputDepth)
+		for y := 0; y < outH; y++ {
+			inputY := y * c.Stride
+			for x := 0; x < outW; x++ {
+				inputX := x * c.Stride
+				inTensor.Crop(inputX, inputY, cropped)
+				colIdx := y*outW + x
+				for row := 0; row < patchSize; row++ {
+					col.Data[row*col.Stride+colIdx] = cropped.Data[row]
+				}
+			}
+		}
+		return col
+	}
+
+	dilation := c.dilation()
+	for oy := 0; oy < outH; oy++ {
+		for ox := 0; ox < outW; ox++ {
+			colIdx := oy*outW + ox
+			row := 0
+			for fy := 0; fy < c.FilterHeight; fy++ {
+				iy := oy*c.Stride - c.Padding + fy*dilation
+				for fx := 0; fx < c.FilterWidth; fx++ {
+					ix := ox*c.Stride - c.Padding + fx*dilation
+					inBounds := ix >= 0 && ix < inTensor.Width && iy >= 0 && iy < inTensor.Height
+					for z := 0; z < c.InputDepth; z++ {
+						var v float32
+						if inBounds {
+							v = inTensor.Get(ix, iy, z)
+						}
+						col.Data[row*col.Stride+colIdx] = v
+						row++
+					}
+				}
+			}
+		}
+	}
+	return col
+}
+
+func (c *ConvLayerF32) filterMatrix() *blas32.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.InputDepth
+	m := &blas32.General{
+		Rows:   c.FilterCount,
+		Cols:   patchSize,
+		Stride: patchSize,
+		Data:   make([]float32, c.FilterCount*patchSize),
+	}
+	for i, filter := range c.Filters {
+		copy(m.Data[i*patchSize:(i+1)*patchSize], filter.Data)
+	}
+	return m
+}
@@ -0,0 +1,47 @@
+package neuralnet
+
+import "testing"
+
+func TestConvLayerF32MatchesFloat64(t *testing.T) {
+	layer := newTestConvLayer()
+	f32Layer := layer.ToFloat32()
+
+	input := make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth)
+	inputF32 := make([]float32, len(input))
+	for i := range input {
+		input[i] = (float64(i%7) - 3) / 3
+		inputF32[i] = float32(input[i])
+	}
+
+	expected := layer.convolve(input)
+	actual := f32Layer.Apply(inputF32)
+
+	if len(expected.Data) != len(actual) {
+		t.Fatalf("output size mismatch: %d vs %d", len(expected.Data), len(actual))
+	}
+	for i, x := range expected.Data {
+		diff := x - float64(actual[i])
+		if diff > 1e-3 || diff < -1e-3 {
+			t.Errorf("output %d: expected %f got %f", i, x, actual[i])
+		}
+	}
+}
+
+func TestConvLayerF32RoundTrip(t *testing.T) {
+	layer := newTestConvLayer()
+	roundTripped := layer.ToFloat32().ToFloat64()
+
+	if roundTripped.FilterCount != layer.FilterCount ||
+		roundTripped.InputWidth != layer.InputWidth ||
+		roundTripped.InputDepth != layer.InputDepth {
+		t.Errorf("round-tripped layer shape does not match original")
+	}
+	for i, filter := range layer.Filters {
+		other := roundTripped.Filters[i]
+		for j, v := range filter.Data {
+			if diff := v - other.Data[j]; diff > 1e-6 || diff < -1e-6 {
+				t.Errorf("filter %d entry %d: expected %f got %f", i, j, v, other.Data[j])
+			}
+		}
+	}
+}
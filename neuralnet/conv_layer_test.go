@@ -0,0 +1,106 @@
+package neuralnet
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/autofunc/functest"
+)
+
+func TestConvLayerIm2ColMatchesLoop(t *testing.T) {
+	layer := newTestConvLayer()
+	input := make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth)
+	for i := range input {
+		input[i] = (float64(i%7) - 3) / 3
+	}
+
+	expected := layer.convolveLoop(input)
+	actual := layer.convolve(input)
+
+	if len(expected.Data) != len(actual.Data) {
+		t.Fatalf("output size mismatch: %d vs %d", len(expected.Data), len(actual.Data))
+	}
+	for i, x := range expected.Data {
+		if diff := x - actual.Data[i]; diff > 1e-8 || diff < -1e-8 {
+			t.Errorf("output %d: expected %f got %f", i, x, actual.Data[i])
+		}
+	}
+}
+
+func TestConvLayerGradients(t *testing.T) {
+	layer := newTestConvLayer()
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	vars := append([]*autofunc.Variable{inVar}, layer.Parameters()...)
+
+	checker := &functest.FuncChecker{
+		F:     layer,
+		Vars:  vars,
+		Input: []autofunc.Result{inVar},
+	}
+	checker.FullCheck(t)
+}
+
+// TestConvLayerRGradientsPaddingDilation checks ApplyR's
+// output and R-gradient against finite differences for a
+// layer with non-zero Padding and Dilation, the case
+// convolveR and PropagateRGradient previously got wrong by
+// ignoring both fields.
+func TestConvLayerRGradientsPaddingDilation(t *testing.T) {
+	layer := &ConvLayer{
+		FilterCount:  2,
+		FilterWidth:  2,
+		FilterHeight: 2,
+		Stride:       2,
+		Padding:      1,
+		Dilation:     2,
+		InputWidth:   5,
+		InputHeight:  5,
+		InputDepth:   2,
+	}
+	layer.Randomize()
+
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	vars := append([]*autofunc.Variable{inVar}, layer.Parameters()...)
+	rv := autofunc.RVector{}
+	for i, v := range vars {
+		vec := make([]float64, len(v.Vector))
+		for j := range vec {
+			vec[j] = (float64((i+j)%3) - 1) / 2
+		}
+		rv[v] = vec
+	}
+
+	checker := &functest.RFuncChecker{
+		F:     layer,
+		Vars:  vars,
+		Input: []autofunc.Result{inVar},
+		RV:    rv,
+	}
+	checker.FullCheck(t)
+}
+
+func newTestConvLayer() *ConvLayer {
+	layer := &ConvLayer{
+		FilterCount:  3,
+		FilterWidth:  2,
+		FilterHeight: 2,
+		Stride:       1,
+		InputWidth:   4,
+		InputHeight:  4,
+		InputDepth:   2,
+	}
+	layer.Randomize()
+	return layer
+}
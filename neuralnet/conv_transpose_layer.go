@@ -0,0 +1,603 @@
+package neuralnet
+
+import (
+	"encoding/json"
+	"math/rand"
+
+	"github.com/gonum/blas/blas64"
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// A ConvTransposeLayer is a transposed convolutional (a.k.a.
+// deconvolutional) layer, useful for upsampling in
+// autoencoders and segmentation models. It computes the
+// transpose of the linear map a ConvLayer with the same
+// Filter/Stride/Padding/Dilation parameters would compute,
+// turning a small, deep input into a larger, shallower one.
+//
+// Unlike ConvLayer.Filters (one filter per output channel,
+// each spanning all input channels), ConvTransposeLayer.Filters
+// holds one filter per input channel, each spanning all output
+// channels: len(Filters) == InputDepth, and each filter is
+// shaped FilterWidth x FilterHeight x FilterCount.
+type ConvTransposeLayer struct {
+	FilterCount  int
+	FilterWidth  int
+	FilterHeight int
+	Stride       int
+
+	// Padding and Dilation mirror the fields of the same name
+	// on ConvLayer, but describe the padding/dilation of the
+	// forward convolution this layer is the transpose of.
+	Padding  int
+	Dilation int
+
+	InputWidth  int
+	InputHeight int
+	InputDepth  int
+
+	Filters    []*Tensor3
+	FilterVars []*autofunc.Variable `json:"-"`
+	Biases     *autofunc.Variable
+}
+
+func DeserializeConvTransposeLayer(data []byte) (*ConvTransposeLayer, error) {
+	var c ConvTransposeLayer
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	for _, x := range c.Filters {
+		v := &autofunc.Variable{Vector: x.Data}
+		c.FilterVars = append(c.FilterVars, v)
+	}
+
+	return &c, nil
+}
+
+// dilation returns the layer's effective dilation, treating a
+// zero value the same as ConvLayer does: no dilation.
+func (c *ConvTransposeLayer) dilation() int {
+	if c.Dilation == 0 {
+		return 1
+	}
+	return c.Dilation
+}
+
+// OutputWidth computes the width of the output tensor.
+func (c *ConvTransposeLayer) OutputWidth() int {
+	w := (c.InputWidth-1)*c.Stride - 2*c.Padding + c.dilation()*(c.FilterWidth-1) + 1
+	if w < 0 {
+		return 0
+	}
+	return w
+}
+
+// OutputHeight computes the height of the output tensor.
+func (c *ConvTransposeLayer) OutputHeight() int {
+	h := (c.InputHeight-1)*c.Stride - 2*c.Padding + c.dilation()*(c.FilterHeight-1) + 1
+	if h < 0 {
+		return 0
+	}
+	return h
+}
+
+// OutputDepth returns the depth of the output tensor.
+func (c *ConvTransposeLayer) OutputDepth() int {
+	return c.FilterCount
+}
+
+// Randomize randomly initializes the layer's filters and
+// biases. This will allocate c.Filters, c.Biases, and
+// c.FilterVars if needed.
+func (c *ConvTransposeLayer) Randomize() {
+	if c.Filters == nil {
+		for i := 0; i < c.InputDepth; i++ {
+			filter := NewTensor3(c.FilterWidth, c.FilterHeight, c.FilterCount)
+			filterVar := &autofunc.Variable{Vector: linalg.Vector(filter.Data)}
+			c.Filters = append(c.Filters, filter)
+			c.FilterVars = append(c.FilterVars, filterVar)
+		}
+	}
+	if c.Biases == nil {
+		biasVec := make(linalg.Vector, c.FilterCount)
+		c.Biases = &autofunc.Variable{Vector: biasVec}
+	}
+	for _, filter := range c.Filters {
+		filter.Randomize()
+	}
+	for i := range c.Biases.Vector {
+		c.Biases.Vector[i] = (rand.Float64() * 2) - 1
+	}
+}
+
+// Parameters returns a slice containing the bias variable and
+// all the filter variables.
+func (c *ConvTransposeLayer) Parameters() []*autofunc.Variable {
+	if c.Filters == nil || c.Biases == nil || c.FilterVars == nil {
+		panic(uninitPanicMessage)
+	}
+	res := make([]*autofunc.Variable, len(c.FilterVars)+1)
+	res[0] = c.Biases
+	copy(res[1:], c.FilterVars)
+	return res
+}
+
+// Apply computes the transposed convolution on the input.
+// The result is only valid as long as the ConvTransposeLayer
+// that produced it (c, in this case) is not modified.
+func (c *ConvTransposeLayer) Apply(in autofunc.Result) autofunc.Result {
+	if c.Filters == nil || c.Biases == nil || c.FilterVars == nil {
+		panic(uninitPanicMessage)
+	}
+	return &convTransposeLayerResult{
+		OutputTensor: c.convolveTranspose(in.Output()),
+		Input:        in,
+		Layer:        c,
+	}
+}
+
+// ApplyR is like Apply, but for autofunc.RResults.
+func (c *ConvTransposeLayer) ApplyR(v autofunc.RVector, in autofunc.RResult) autofunc.RResult {
+	if c.Filters == nil || c.Biases == nil || c.FilterVars == nil {
+		panic(uninitPanicMessage)
+	}
+	return &convTransposeLayerRResult{
+		OutputTensor:  c.convolveTranspose(in.Output()),
+		ROutputTensor: c.convolveTransposeR(v, in.Output(), in.ROutput()),
+		Input:         in,
+		Layer:         c,
+		RV:            v,
+	}
+}
+
+func (c *ConvTransposeLayer) Serialize() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *ConvTransposeLayer) SerializerType() string {
+	return serializerTypeConvTransposeLayer
+}
+
+// filterMatrix flattens the layer's filters into an
+// InputDepth x (FilterWidth*FilterHeight*FilterCount) matrix,
+// one filter per row.
+func (c *ConvTransposeLayer) filterMatrix() *blas64.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.FilterCount
+	m := &blas64.General{
+		Rows:   c.InputDepth,
+		Cols:   patchSize,
+		Stride: patchSize,
+		Data:   make([]float64, c.InputDepth*patchSize),
+	}
+	for i, filter := range c.Filters {
+		copy(m.Data[i*patchSize:(i+1)*patchSize], filter.Data)
+	}
+	return m
+}
+
+// filterMatrixR is the filterMatrix counterpart for an
+// autofunc.RVector: it flattens the R-derivative of each
+// filter into the same shape filterMatrix produces, treating
+// filters with no entry in v as having a zero R-derivative.
+func (c *ConvTransposeLayer) filterMatrixR(v autofunc.RVector) *blas64.General {
+	patchSize := c.FilterWidth * c.FilterHeight * c.FilterCount
+	m := &blas64.General{
+		Rows:   c.InputDepth,
+		Cols:   patchSize,
+		Stride: patchSize,
+		Data:   make([]float64, c.InputDepth*patchSize),
+	}
+	for i, filterVar := range c.FilterVars {
+		if data := v[filterVar]; data != nil {
+			copy(m.Data[i*patchSize:(i+1)*patchSize], data)
+		}
+	}
+	return m
+}
+
+// inputMatrix flattens inTensor into an
+// InputDepth x (InputWidth*InputHeight) matrix.
+func (c *ConvTransposeLayer) inputMatrix(inTensor *Tensor3) *blas64.General {
+	hw := c.InputWidth * c.InputHeight
+	m := &blas64.General{
+		Rows:   c.InputDepth,
+		Cols:   hw,
+		Stride: hw,
+		Data:   make([]float64, c.InputDepth*hw),
+	}
+	for z := 0; z < c.InputDepth; z++ {
+		for idx := 0; idx < hw; idx++ {
+			x := idx % c.InputWidth
+			y := idx / c.InputWidth
+			m.Data[z*m.Stride+idx] = inTensor.Get(x, y, z)
+		}
+	}
+	return m
+}
+
+// convolveTranspose computes the forward pass. It is the
+// transpose of ConvLayer's forward GEMM: instead of
+// im2col(input) followed by filterMat*col, it computes
+// filterMat^T*inputMat and then col2im's the result into the
+// (larger) output tensor.
+func (c *ConvTransposeLayer) convolveTranspose(input linalg.Vector) *Tensor3 {
+	inTensor := c.inputToTensor(input)
+	inputMat := c.inputMatrix(inTensor)
+	filterMat := c.filterMatrix()
+
+	patchSize := c.FilterWidth * c.FilterHeight * c.FilterCount
+	hw := c.InputWidth * c.InputHeight
+	colGrad := getPooledMatrix(patchSize, hw)
+	gemmTN(1, filterMat, inputMat, 0, colGrad)
+
+	outW, outH := c.OutputWidth(), c.OutputHeight()
+	outTensor := col2imPadded(colGrad, outW, outH, c.FilterCount,
+		c.FilterWidth, c.FilterHeight, c.Stride, c.Padding, c.dilation(),
+		c.InputWidth, c.InputHeight, false)
+	dropMatrix(colGrad)
+
+	for z := 0; z < c.FilterCount; z++ {
+		bias := c.Biases.Vector[z]
+		for y := 0; y < outH; y++ {
+			for x := 0; x < outW; x++ {
+				outTensor.Set(x, y, z, outTensor.Get(x, y, z)+bias)
+			}
+		}
+	}
+
+	return outTensor
+}
+
+// convolveTransposeR is the R-derivative counterpart of
+// convolveTranspose, differentiating its single GEMM
+// (filterMat^T*inputMat) via the product rule, the same way
+// ConvLayer's convolveR differentiates convolve's GEMM.
+func (c *ConvTransposeLayer) convolveTransposeR(v autofunc.RVector, input, inputR linalg.Vector) *Tensor3 {
+	inTensor := c.inputToTensor(input)
+	inTensorR := c.inputToTensor(inputR)
+	inputMat := c.inputMatrix(inTensor)
+	inputMatR := c.inputMatrix(inTensorR)
+	filterMat := c.filterMatrix()
+	filterMatR := c.filterMatrixR(v)
+
+	patchSize := c.FilterWidth * c.FilterHeight * c.FilterCount
+	hw := c.InputWidth * c.InputHeight
+	colGradR := getPooledMatrix(patchSize, hw)
+	gemmTN(1, filterMatR, inputMat, 0, colGradR)
+	gemmTN(1, filterMat, inputMatR, 1, colGradR)
+
+	outW, outH := c.OutputWidth(), c.OutputHeight()
+	outTensorR := col2imPadded(colGradR, outW, outH, c.FilterCount,
+		c.FilterWidth, c.FilterHeight, c.Stride, c.Padding, c.dilation(),
+		c.InputWidth, c.InputHeight, false)
+	dropMatrix(colGradR)
+
+	biasR := v[c.Biases]
+	if biasR != nil {
+		for z := 0; z < c.FilterCount; z++ {
+			for y := 0; y < outH; y++ {
+				for x := 0; x < outW; x++ {
+					outTensorR.Set(x, y, z, outTensorR.Get(x, y, z)+biasR[z])
+				}
+			}
+		}
+	}
+
+	return outTensorR
+}
+
+func (c *ConvTransposeLayer) inputToTensor(in linalg.Vector) *Tensor3 {
+	return &Tensor3{
+		Width:  c.InputWidth,
+		Height: c.InputHeight,
+		Depth:  c.InputDepth,
+		Data:   in,
+	}
+}
+
+func (c *ConvTransposeLayer) outputToTensor(out linalg.Vector) *Tensor3 {
+	return &Tensor3{
+		Width:  c.OutputWidth(),
+		Height: c.OutputHeight(),
+		Depth:  c.OutputDepth(),
+		Data:   out,
+	}
+}
+
+func (c *ConvTransposeLayer) gradsFromMap(m map[*autofunc.Variable]linalg.Vector) (bias linalg.Vector,
+	filters []*Tensor3) {
+	if m == nil {
+		for range c.FilterVars {
+			filters = append(filters, nil)
+		}
+		return
+	}
+
+	bias = m[c.Biases]
+
+	for _, v := range c.FilterVars {
+		if gradVec := m[v]; gradVec == nil {
+			filters = append(filters, nil)
+		} else {
+			filters = append(filters, &Tensor3{
+				Width:  c.FilterWidth,
+				Height: c.FilterHeight,
+				Depth:  c.FilterCount,
+				Data:   gradVec,
+			})
+		}
+	}
+
+	return
+}
+
+type convTransposeLayerResult struct {
+	OutputTensor *Tensor3
+	Input        autofunc.Result
+	Layer        *ConvTransposeLayer
+}
+
+func (c *convTransposeLayerResult) Output() linalg.Vector {
+	return c.OutputTensor.Data
+}
+
+func (c *convTransposeLayerResult) Constant(g autofunc.Gradient) bool {
+	if !c.Layer.Biases.Constant(g) {
+		return false
+	}
+	if !c.Input.Constant(g) {
+		return false
+	}
+	for _, x := range c.Layer.FilterVars {
+		if !x.Constant(g) {
+			return false
+		}
+	}
+	return true
+}
+
+// PropagateGradient back-propagates through the transposed
+// convolution. This mirrors ConvLayer's forward/backward pair:
+// the filter and input gradients are computed by im2col'ing
+// the upstream gradient (as if it were the input to a forward
+// ConvLayer) and then running ConvLayer's GEMMs on it: the
+// input gradient is its forward GEMM (filterMat*col), and the
+// filter gradients are its filter-gradient GEMM (input*col^T).
+func (c *convTransposeLayerResult) PropagateGradient(upstream linalg.Vector, grad autofunc.Gradient) {
+	layer := c.Layer
+	outW, outH := c.OutputTensor.Width, c.OutputTensor.Height
+	patchSize := layer.FilterWidth * layer.FilterHeight * layer.FilterCount
+
+	downstreamTensor := layer.outputToTensor(upstream)
+
+	biasGrad, filterGrads := layer.gradsFromMap(grad)
+	if biasGrad != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			for y := 0; y < outH; y++ {
+				for x := 0; x < outW; x++ {
+					biasGrad[z] += downstreamTensor.Get(x, y, z)
+				}
+			}
+		}
+	}
+
+	needFilterGrad := false
+	for _, f := range filterGrads {
+		if f != nil {
+			needFilterGrad = true
+		}
+	}
+	needInputGrad := !c.Input.Constant(grad)
+
+	if !needFilterGrad && !needInputGrad {
+		return
+	}
+
+	colUp := im2colPadded(downstreamTensor, layer.FilterWidth, layer.FilterHeight,
+		layer.FilterCount, layer.Stride, layer.Padding, layer.dilation(),
+		layer.InputWidth, layer.InputHeight)
+	defer dropMatrix(colUp)
+
+	if needFilterGrad {
+		inputMat := layer.inputMatrix(layer.inputToTensor(c.Input.Output()))
+		filterGradMat := getPooledMatrix(layer.InputDepth, patchSize)
+		gemmNT(1, inputMat, colUp, 0, filterGradMat)
+		for i, filterGrad := range filterGrads {
+			if filterGrad == nil {
+				continue
+			}
+			row := filterGradMat.Data[i*filterGradMat.Stride : i*filterGradMat.Stride+patchSize]
+			for j, v := range row {
+				filterGrad.Data[j] += v
+			}
+		}
+		dropMatrix(filterGradMat)
+	}
+
+	if needInputGrad {
+		filterMat := layer.filterMatrix()
+		hw := layer.InputWidth * layer.InputHeight
+		inputGradMat := getPooledMatrix(layer.InputDepth, hw)
+		gemmNN(1, filterMat, colUp, 0, inputGradMat)
+
+		inputGrad := getPooledTensor3(layer.InputWidth, layer.InputHeight, layer.InputDepth)
+		for z := 0; z < layer.InputDepth; z++ {
+			for idx := 0; idx < hw; idx++ {
+				x := idx % layer.InputWidth
+				y := idx / layer.InputWidth
+				inputGrad.Set(x, y, z, inputGradMat.Data[z*inputGradMat.Stride+idx])
+			}
+		}
+		dropMatrix(inputGradMat)
+		c.Input.PropagateGradient(inputGrad.Data, grad)
+		inputGrad.Drop()
+	}
+}
+
+type convTransposeLayerRResult struct {
+	OutputTensor  *Tensor3
+	ROutputTensor *Tensor3
+	Input         autofunc.RResult
+	Layer         *ConvTransposeLayer
+	RV            autofunc.RVector
+}
+
+func (c *convTransposeLayerRResult) Output() linalg.Vector {
+	return c.OutputTensor.Data
+}
+
+func (c *convTransposeLayerRResult) ROutput() linalg.Vector {
+	return c.ROutputTensor.Data
+}
+
+func (c *convTransposeLayerRResult) Constant(rg autofunc.RGradient, g autofunc.Gradient) bool {
+	if !c.Input.Constant(rg, g) {
+		return false
+	}
+
+	if !c.Layer.Biases.Constant(g) {
+		return false
+	} else if _, ok := rg[c.Layer.Biases]; ok {
+		return false
+	}
+
+	for _, x := range c.Layer.FilterVars {
+		if !x.Constant(g) {
+			return false
+		} else if _, ok := rg[x]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PropagateRGradient is the R-derivative counterpart of
+// PropagateGradient, differentiating each of its GEMMs via
+// the product rule, the same way convLayerRResult.PropagateRGradient
+// differentiates convLayerResult.PropagateGradient's GEMMs.
+func (c *convTransposeLayerRResult) PropagateRGradient(upstream, upstreamR linalg.Vector,
+	rgrad autofunc.RGradient, grad autofunc.Gradient) {
+	layer := c.Layer
+	outW, outH := c.OutputTensor.Width, c.OutputTensor.Height
+	patchSize := layer.FilterWidth * layer.FilterHeight * layer.FilterCount
+
+	downstreamTensor := layer.outputToTensor(upstream)
+	downstreamTensorR := layer.outputToTensor(upstreamR)
+
+	biasGrad, filterGrads := layer.gradsFromMap(grad)
+	biasGradR, filterGradsR := layer.gradsFromMap(rgrad)
+
+	if biasGrad != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			for y := 0; y < outH; y++ {
+				for x := 0; x < outW; x++ {
+					biasGrad[z] += downstreamTensor.Get(x, y, z)
+				}
+			}
+		}
+	}
+	if biasGradR != nil {
+		for z := 0; z < layer.FilterCount; z++ {
+			for y := 0; y < outH; y++ {
+				for x := 0; x < outW; x++ {
+					biasGradR[z] += downstreamTensorR.Get(x, y, z)
+				}
+			}
+		}
+	}
+
+	needFilterGrad := false
+	for _, f := range filterGrads {
+		if f != nil {
+			needFilterGrad = true
+		}
+	}
+	needFilterGradR := false
+	for _, f := range filterGradsR {
+		if f != nil {
+			needFilterGradR = true
+		}
+	}
+	needInputGrad := !c.Input.Constant(rgrad, grad)
+
+	if !needFilterGrad && !needFilterGradR && !needInputGrad {
+		return
+	}
+
+	colUp := im2colPadded(downstreamTensor, layer.FilterWidth, layer.FilterHeight,
+		layer.FilterCount, layer.Stride, layer.Padding, layer.dilation(),
+		layer.InputWidth, layer.InputHeight)
+	defer dropMatrix(colUp)
+	var colUpR *blas64.General
+	if needFilterGradR || needInputGrad {
+		colUpR = im2colPadded(downstreamTensorR, layer.FilterWidth, layer.FilterHeight,
+			layer.FilterCount, layer.Stride, layer.Padding, layer.dilation(),
+			layer.InputWidth, layer.InputHeight)
+		defer dropMatrix(colUpR)
+	}
+
+	if needFilterGrad {
+		inputMat := layer.inputMatrix(layer.inputToTensor(c.Input.Output()))
+		filterGradMat := getPooledMatrix(layer.InputDepth, patchSize)
+		gemmNT(1, inputMat, colUp, 0, filterGradMat)
+		for i, filterGrad := range filterGrads {
+			if filterGrad == nil {
+				continue
+			}
+			row := filterGradMat.Data[i*filterGradMat.Stride : i*filterGradMat.Stride+patchSize]
+			for j, v := range row {
+				filterGrad.Data[j] += v
+			}
+		}
+		dropMatrix(filterGradMat)
+	}
+	if needFilterGradR {
+		inputMat := layer.inputMatrix(layer.inputToTensor(c.Input.Output()))
+		inputMatR := layer.inputMatrix(layer.inputToTensor(c.Input.ROutput()))
+		filterGradMatR := getPooledMatrix(layer.InputDepth, patchSize)
+		gemmNT(1, inputMatR, colUp, 0, filterGradMatR)
+		gemmNT(1, inputMat, colUpR, 1, filterGradMatR)
+		for i, filterGradR := range filterGradsR {
+			if filterGradR == nil {
+				continue
+			}
+			row := filterGradMatR.Data[i*filterGradMatR.Stride : i*filterGradMatR.Stride+patchSize]
+			for j, v := range row {
+				filterGradR.Data[j] += v
+			}
+		}
+		dropMatrix(filterGradMatR)
+	}
+
+	if needInputGrad {
+		filterMat := layer.filterMatrix()
+		filterMatR := layer.filterMatrixR(c.RV)
+		hw := layer.InputWidth * layer.InputHeight
+
+		inputGradMat := getPooledMatrix(layer.InputDepth, hw)
+		gemmNN(1, filterMat, colUp, 0, inputGradMat)
+
+		inputGradMatR := getPooledMatrix(layer.InputDepth, hw)
+		gemmNN(1, filterMatR, colUp, 0, inputGradMatR)
+		gemmNN(1, filterMat, colUpR, 1, inputGradMatR)
+
+		inputGrad := getPooledTensor3(layer.InputWidth, layer.InputHeight, layer.InputDepth)
+		inputGradR := getPooledTensor3(layer.InputWidth, layer.InputHeight, layer.InputDepth)
+		for z := 0; z < layer.InputDepth; z++ {
+			for idx := 0; idx < hw; idx++ {
+				x := idx % layer.InputWidth
+				y := idx / layer.InputWidth
+				inputGrad.Set(x, y, z, inputGradMat.Data[z*inputGradMat.Stride+idx])
+				inputGradR.Set(x, y, z, inputGradMatR.Data[z*inputGradMatR.Stride+idx])
+			}
+		}
+		dropMatrix(inputGradMat)
+		dropMatrix(inputGradMatR)
+		c.Input.PropagateRGradient(inputGrad.Data, inputGradR.Data, rgrad, grad)
+		inputGrad.Drop()
+		inputGradR.Drop()
+	}
+}
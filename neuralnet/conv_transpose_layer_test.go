@@ -0,0 +1,129 @@
+package neuralnet
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/autofunc/functest"
+)
+
+func TestConvTransposeLayerOutputSize(t *testing.T) {
+	layer := newTestConvTransposeLayer()
+	if w := layer.OutputWidth(); w != 7 {
+		t.Errorf("expected output width 7, got %d", w)
+	}
+	if h := layer.OutputHeight(); h != 7 {
+		t.Errorf("expected output height 7, got %d", h)
+	}
+	if d := layer.OutputDepth(); d != layer.FilterCount {
+		t.Errorf("expected output depth %d, got %d", layer.FilterCount, d)
+	}
+}
+
+func TestConvTransposeLayerGradients(t *testing.T) {
+	layer := newTestConvTransposeLayer()
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	vars := append([]*autofunc.Variable{inVar}, layer.Parameters()...)
+
+	checker := &functest.FuncChecker{
+		F:     layer,
+		Vars:  vars,
+		Input: []autofunc.Result{inVar},
+	}
+	checker.FullCheck(t)
+}
+
+// TestConvTransposeLayerRGradients checks ApplyR's output and
+// R-gradient against finite differences, now that
+// ConvTransposeLayer implements autofunc.RFunc.
+func TestConvTransposeLayerRGradients(t *testing.T) {
+	layer := newTestConvTransposeLayer()
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	vars := append([]*autofunc.Variable{inVar}, layer.Parameters()...)
+	rv := autofunc.RVector{}
+	for i, v := range vars {
+		vec := make([]float64, len(v.Vector))
+		for j := range vec {
+			vec[j] = (float64((i+j)%3) - 1) / 2
+		}
+		rv[v] = vec
+	}
+
+	checker := &functest.RFuncChecker{
+		F:     layer,
+		Vars:  vars,
+		Input: []autofunc.Result{inVar},
+		RV:    rv,
+	}
+	checker.FullCheck(t)
+}
+
+// TestConvTransposeLayerRGradientsPaddingDilation checks
+// ApplyR's output and R-gradient against finite differences
+// for a layer with non-zero Padding and Dilation, mirroring
+// TestConvLayerRGradientsPaddingDilation.
+func TestConvTransposeLayerRGradientsPaddingDilation(t *testing.T) {
+	layer := &ConvTransposeLayer{
+		FilterCount:  2,
+		FilterWidth:  3,
+		FilterHeight: 3,
+		Stride:       2,
+		Padding:      1,
+		Dilation:     2,
+		InputWidth:   3,
+		InputHeight:  3,
+		InputDepth:   4,
+	}
+	layer.Randomize()
+
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	vars := append([]*autofunc.Variable{inVar}, layer.Parameters()...)
+	rv := autofunc.RVector{}
+	for i, v := range vars {
+		vec := make([]float64, len(v.Vector))
+		for j := range vec {
+			vec[j] = (float64((i+j)%3) - 1) / 2
+		}
+		rv[v] = vec
+	}
+
+	checker := &functest.RFuncChecker{
+		F:     layer,
+		Vars:  vars,
+		Input: []autofunc.Result{inVar},
+		RV:    rv,
+	}
+	checker.FullCheck(t)
+}
+
+func newTestConvTransposeLayer() *ConvTransposeLayer {
+	layer := &ConvTransposeLayer{
+		FilterCount:  2,
+		FilterWidth:  3,
+		FilterHeight: 3,
+		Stride:       2,
+		InputWidth:   3,
+		InputHeight:  3,
+		InputDepth:   4,
+	}
+	layer.Randomize()
+	return layer
+}
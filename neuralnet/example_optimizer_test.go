@@ -0,0 +1,43 @@
+package neuralnet
+
+import (
+	"fmt"
+
+	"github.com/unixpickle/autofunc"
+)
+
+// ExampleAdam shows the shape of a training loop that uses
+// Adam to update a ConvLayer's parameters from a batch
+// gradient, in place of manually scaled SGD.
+func ExampleAdam() {
+	layer := &ConvLayer{
+		FilterCount:  4,
+		FilterWidth:  3,
+		FilterHeight: 3,
+		Stride:       1,
+		InputWidth:   28,
+		InputHeight:  28,
+		InputDepth:   1,
+	}
+	layer.Randomize()
+
+	optimizer := NewAdam(layer.Parameters())
+
+	for step := 0; step < 3; step++ {
+		input := &autofunc.Variable{Vector: make([]float64, 28*28)}
+		output := layer.Apply(input)
+
+		upstream := make([]float64, len(output.Output()))
+		for i := range upstream {
+			upstream[i] = 1
+		}
+
+		grad := autofunc.NewGradient(layer.Parameters())
+		output.PropagateGradient(upstream, grad)
+
+		optimizer.Step(grad, 0.001)
+	}
+
+	fmt.Println("trained for 3 steps")
+	// Output: trained for 3 steps
+}
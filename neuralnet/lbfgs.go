@@ -0,0 +1,202 @@
+package neuralnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// LBFGS is a limited-memory BFGS optimizer. Unlike RMSProp,
+// Adam, and AdamW, it is not an Optimizer: a quasi-Newton
+// method needs to re-evaluate the objective at several trial
+// points per step (for its line search), not just apply a
+// fixed update to a single gradient, so its Step method takes
+// a closure that computes loss and gradient on demand rather
+// than a pre-computed autofunc.Gradient.
+//
+// LBFGS tends to converge in far fewer steps than Adam on
+// small or medium batch-mode problems, where the cost of the
+// extra objective evaluations per step is outweighed by needing
+// many fewer steps overall.
+type LBFGS struct {
+	// Memory is the number of (s, y) pairs to keep. Defaults
+	// to 10 if left at 0 when Step is first called.
+	Memory int
+
+	// LineSearchIters caps the number of step-size halvings
+	// tried by the backtracking line search. Defaults to 20
+	// if left at 0 when Step is first called.
+	LineSearchIters int
+
+	vars []*autofunc.Variable
+
+	// sHistory and yHistory are ring buffers of the last
+	// Memory (s_k, y_k) pairs, newest last.
+	sHistory []linalg.Vector
+	yHistory []linalg.Vector
+	rho      []float64
+}
+
+// NewLBFGS creates an LBFGS optimizer that will update vars,
+// keeping the last memory (s, y) pairs for the two-loop
+// recursion.
+func NewLBFGS(vars []*autofunc.Variable, memory int) *LBFGS {
+	return &LBFGS{Memory: memory, vars: vars}
+}
+
+// Step runs one iteration of L-BFGS: it computes a search
+// direction from the two-loop recursion over the optimizer's
+// history, then backtracking-line-searches along it for a
+// step size satisfying the Armijo condition. objective is
+// called once up front (to get the current loss/gradient) and
+// once per line-search trial; it must compute loss and
+// gradient from the optimizer's vars as they currently stand.
+//
+// Step returns the loss after the accepted step (or the
+// original loss, if no step satisfied the Armijo condition).
+func (l *LBFGS) Step(objective func() (loss float64, grad autofunc.Gradient)) float64 {
+	if l.Memory == 0 {
+		l.Memory = 10
+	}
+	if l.LineSearchIters == 0 {
+		l.LineSearchIters = 20
+	}
+
+	loss, grad := objective()
+	flatGrad := l.flattenGrad(grad)
+	direction := l.twoLoopRecursion(flatGrad)
+
+	x0 := l.flattenVars()
+	gDotDir := dotProduct(flatGrad, direction)
+
+	const armijoC1 = 1e-4
+	const shrink = 0.5
+
+	stepSize := 1.0
+	for iter := 0; iter < l.LineSearchIters; iter++ {
+		l.setVars(x0, direction, stepSize)
+		newLoss, newGrad := objective()
+		if newLoss <= loss+armijoC1*stepSize*gDotDir {
+			newFlatGrad := l.flattenGrad(newGrad)
+			s := scaledCopy(direction, stepSize)
+			y := subtract(newFlatGrad, flatGrad)
+			l.pushHistory(s, y)
+			return newLoss
+		}
+		stepSize *= shrink
+	}
+
+	// No step satisfied the Armijo condition; leave the
+	// variables as they were.
+	l.setVars(x0, direction, 0)
+	return loss
+}
+
+// twoLoopRecursion computes the L-BFGS search direction from
+// the current gradient and the optimizer's (s, y) history,
+// using Nocedal & Wright's standard two-loop recursion.
+func (l *LBFGS) twoLoopRecursion(grad linalg.Vector) linalg.Vector {
+	q := append(linalg.Vector{}, grad...)
+	n := len(l.sHistory)
+	alphas := make([]float64, n)
+
+	for i := n - 1; i >= 0; i-- {
+		alphas[i] = l.rho[i] * dotProduct(l.sHistory[i], q)
+		q = subtract(q, scaledCopy(l.yHistory[i], alphas[i]))
+	}
+
+	gamma := 1.0
+	if n > 0 {
+		sLast := l.sHistory[n-1]
+		yLast := l.yHistory[n-1]
+		denom := dotProduct(yLast, yLast)
+		if denom != 0 {
+			gamma = dotProduct(sLast, yLast) / denom
+		}
+	}
+	q = scaledCopy(q, gamma)
+
+	for i := 0; i < n; i++ {
+		beta := l.rho[i] * dotProduct(l.yHistory[i], q)
+		q = add(q, scaledCopy(l.sHistory[i], alphas[i]-beta))
+	}
+
+	return scaledCopy(q, -1)
+}
+
+// pushHistory appends (s, y) to the ring buffer, evicting the
+// oldest pair once Memory is exceeded.
+func (l *LBFGS) pushHistory(s, y linalg.Vector) {
+	sy := dotProduct(s, y)
+	if sy <= 0 {
+		// Curvature condition violated; skip this pair rather
+		// than corrupting the Hessian approximation.
+		return
+	}
+	l.sHistory = append(l.sHistory, s)
+	l.yHistory = append(l.yHistory, y)
+	l.rho = append(l.rho, 1/sy)
+	if len(l.sHistory) > l.Memory {
+		l.sHistory = l.sHistory[1:]
+		l.yHistory = l.yHistory[1:]
+		l.rho = l.rho[1:]
+	}
+}
+
+func (l *LBFGS) flattenGrad(grad autofunc.Gradient) linalg.Vector {
+	var res linalg.Vector
+	for _, v := range l.vars {
+		res = append(res, grad[v]...)
+	}
+	return res
+}
+
+func (l *LBFGS) flattenVars() linalg.Vector {
+	var res linalg.Vector
+	for _, v := range l.vars {
+		res = append(res, v.Vector...)
+	}
+	return res
+}
+
+// setVars sets every tracked variable to x0 + stepSize*direction.
+func (l *LBFGS) setVars(x0, direction linalg.Vector, stepSize float64) {
+	offset := 0
+	for _, v := range l.vars {
+		for i := range v.Vector {
+			v.Vector[i] = x0[offset+i] + stepSize*direction[offset+i]
+		}
+		offset += len(v.Vector)
+	}
+}
+
+func dotProduct(a, b linalg.Vector) float64 {
+	var sum float64
+	for i, x := range a {
+		sum += x * b[i]
+	}
+	return sum
+}
+
+func scaledCopy(v linalg.Vector, scale float64) linalg.Vector {
+	res := make(linalg.Vector, len(v))
+	for i, x := range v {
+		res[i] = x * scale
+	}
+	return res
+}
+
+func subtract(a, b linalg.Vector) linalg.Vector {
+	res := make(linalg.Vector, len(a))
+	for i, x := range a {
+		res[i] = x - b[i]
+	}
+	return res
+}
+
+func add(a, b linalg.Vector) linalg.Vector {
+	res := make(linalg.Vector, len(a))
+	for i, x := range a {
+		res[i] = x + b[i]
+	}
+	return res
+}
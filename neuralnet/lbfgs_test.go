@@ -0,0 +1,34 @@
+package neuralnet
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+)
+
+// TestLBFGSMinimizesQuadratic checks that L-BFGS converges on
+// a simple convex quadratic, f(x) = sum(x_i^2), whose gradient
+// is 2x and whose minimum is 0.
+func TestLBFGSMinimizesQuadratic(t *testing.T) {
+	v := &autofunc.Variable{Vector: []float64{3, -4, 1.5}}
+	optimizer := NewLBFGS([]*autofunc.Variable{v}, 5)
+
+	objective := func() (float64, autofunc.Gradient) {
+		var loss float64
+		grad := make([]float64, len(v.Vector))
+		for i, x := range v.Vector {
+			loss += x * x
+			grad[i] = 2 * x
+		}
+		return loss, autofunc.Gradient{v: grad}
+	}
+
+	var loss float64
+	for i := 0; i < 30; i++ {
+		loss = optimizer.Step(objective)
+	}
+
+	if loss > 1e-6 {
+		t.Errorf("expected loss near 0 after 30 steps, got %f", loss)
+	}
+}
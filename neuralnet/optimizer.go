@@ -0,0 +1,35 @@
+package neuralnet
+
+import (
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// An Optimizer applies one step of a gradient-based update
+// rule to a set of variables, given their gradients. Unlike
+// the plain SGD updates callers can apply by hand via
+// autofunc.Gradient, an Optimizer may maintain its own
+// per-variable state (e.g. moving averages) across calls.
+type Optimizer interface {
+	// Step updates the variables the Optimizer was created
+	// with in place, using the gradients stored in grad and
+	// the given learning rate.
+	Step(grad autofunc.Gradient, lr float64)
+
+	// Serialize encodes the optimizer's internal state (but
+	// not the variables themselves) so training can resume
+	// from a checkpoint. The corresponding Deserialize*
+	// function must be given the same variables, in the same
+	// order, that the Optimizer was originally created with.
+	Serialize() ([]byte, error)
+}
+
+// zeroVectors allocates one zeroed linalg.Vector per variable,
+// matching each variable's length.
+func zeroVectors(vars []*autofunc.Variable) []linalg.Vector {
+	res := make([]linalg.Vector, len(vars))
+	for i, v := range vars {
+		res[i] = make(linalg.Vector, len(v.Vector))
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package neuralnet
+
+import (
+	"math"
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+)
+
+func TestAdamStep(t *testing.T) {
+	v := &autofunc.Variable{Vector: []float64{1, -1}}
+	optimizer := NewAdam([]*autofunc.Variable{v})
+
+	grad := autofunc.Gradient{v: []float64{0.1, -0.2}}
+	optimizer.Step(grad, 0.1)
+
+	mHat := (1 - 0.9) * 0.1 / (1 - 0.9)
+	vHatFirst := (1 - 0.999) * 0.1 * 0.1 / (1 - 0.999)
+	expected0 := 1 - 0.1*mHat/(math.Sqrt(vHatFirst)+1e-8)
+
+	if diff := v.Vector[0] - expected0; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("expected %f got %f", expected0, v.Vector[0])
+	}
+}
+
+func TestRMSPropReducesGradientScale(t *testing.T) {
+	v := &autofunc.Variable{Vector: []float64{0}}
+	optimizer := NewRMSProp([]*autofunc.Variable{v})
+
+	for i := 0; i < 5; i++ {
+		grad := autofunc.Gradient{v: []float64{1}}
+		optimizer.Step(grad, 1)
+	}
+
+	// The squared-gradient average starts at zero, so with a
+	// constant unit gradient the first step is lr/sqrt(1-Decay)
+	// (about 3.16) and later steps shrink toward lr as the
+	// average warms up: 3.16+2.29+1.92+1.71+1.56, about 10.65.
+	if v.Vector[0] > -10.6 || v.Vector[0] < -10.7 {
+		t.Errorf("expected about -10.65, got %f", v.Vector[0])
+	}
+}
+
+func TestAdamWAppliesWeightDecay(t *testing.T) {
+	v := &autofunc.Variable{Vector: []float64{1}}
+	optimizer := NewAdamW([]*autofunc.Variable{v})
+	optimizer.WeightDecay = 0.1
+
+	grad := autofunc.Gradient{v: []float64{0}}
+	optimizer.Step(grad, 1)
+
+	if diff := v.Vector[0] - 0.9; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("expected weight decay to shrink variable to 0.9, got %f", v.Vector[0])
+	}
+}
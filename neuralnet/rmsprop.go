@@ -0,0 +1,85 @@
+package neuralnet
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/gonum/blas/blas64"
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// RMSProp is an Optimizer that divides each gradient by a
+// decaying moving average of its recent squared magnitude,
+// keeping the per-variable effective step size roughly
+// constant even when gradients vary widely in scale.
+type RMSProp struct {
+	Decay   float64
+	Epsilon float64
+
+	vars  []*autofunc.Variable
+	sqAvg []linalg.Vector
+}
+
+// NewRMSProp creates an RMSProp optimizer that will update
+// vars. Decay defaults to 0.9 and Epsilon to 1e-8, matching
+// common defaults elsewhere.
+func NewRMSProp(vars []*autofunc.Variable) *RMSProp {
+	return &RMSProp{
+		Decay:   0.9,
+		Epsilon: 1e-8,
+		vars:    vars,
+		sqAvg:   zeroVectors(vars),
+	}
+}
+
+// Step updates the tracked variables in place.
+func (r *RMSProp) Step(grad autofunc.Gradient, lr float64) {
+	for i, v := range r.vars {
+		g := grad[v]
+		if g == nil {
+			continue
+		}
+		avg := r.sqAvg[i]
+		for j, gj := range g {
+			avg[j] = r.Decay*avg[j] + (1-r.Decay)*gj*gj
+		}
+		update := make(linalg.Vector, len(g))
+		for j, gj := range g {
+			update[j] = -lr * gj / (math.Sqrt(avg[j]) + r.Epsilon)
+		}
+		dest := blas64.Vector{Inc: 1, Data: v.Vector}
+		src := blas64.Vector{Inc: 1, Data: update}
+		blas64.Axpy(len(update), 1, src, dest)
+	}
+}
+
+type rmsPropState struct {
+	Decay   float64
+	Epsilon float64
+	SqAvg   []linalg.Vector
+}
+
+// Serialize encodes r's state. See Optimizer.Serialize.
+func (r *RMSProp) Serialize() ([]byte, error) {
+	return json.Marshal(&rmsPropState{
+		Decay:   r.Decay,
+		Epsilon: r.Epsilon,
+		SqAvg:   r.sqAvg,
+	})
+}
+
+// DeserializeRMSProp decodes an RMSProp optimizer previously
+// encoded with Serialize, re-attaching it to vars.
+func DeserializeRMSProp(data []byte, vars []*autofunc.Variable) (*RMSProp, error) {
+	var s rmsPropState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &RMSProp{
+		Decay:   s.Decay,
+		Epsilon: s.Epsilon,
+		vars:    vars,
+		sqAvg:   s.SqAvg,
+	}, nil
+}
@@ -0,0 +1,12 @@
+package neuralnet
+
+import "github.com/unixpickle/serializer"
+
+const serializerTypeConvTransposeLayer = "github.com/unixpickle/weakai/neuralnet.ConvTransposeLayer"
+
+func init() {
+	serializer.RegisterDeserializer(serializerTypeConvTransposeLayer,
+		func(d []byte) (serializer.Serializer, error) {
+			return DeserializeConvTransposeLayer(d)
+		})
+}
@@ -0,0 +1,90 @@
+package neuralnet
+
+import "math/rand"
+
+// Tensor3F32 is the float32 counterpart of Tensor3, used by
+// the F32 layer variants to cut the memory footprint of large
+// inference-only networks roughly in half.
+type Tensor3F32 struct {
+	Width  int
+	Height int
+	Depth  int
+	Data   []float32
+}
+
+// NewTensor3F32 creates a zeroed Tensor3F32 of the given
+// dimensions.
+func NewTensor3F32(width, height, depth int) *Tensor3F32 {
+	return &Tensor3F32{
+		Width:  width,
+		Height: height,
+		Depth:  depth,
+		Data:   make([]float32, width*height*depth),
+	}
+}
+
+// Tensor3ToF32 down-casts a float64 Tensor3 into a Tensor3F32
+// with the same dimensions.
+func Tensor3ToF32(t *Tensor3) *Tensor3F32 {
+	res := NewTensor3F32(t.Width, t.Height, t.Depth)
+	for i, x := range t.Data {
+		res.Data[i] = float32(x)
+	}
+	return res
+}
+
+// Tensor3FromF32 up-casts a Tensor3F32 into a float64 Tensor3
+// with the same dimensions.
+func Tensor3FromF32(t *Tensor3F32) *Tensor3 {
+	res := NewTensor3(t.Width, t.Height, t.Depth)
+	for i, x := range t.Data {
+		res.Data[i] = float64(x)
+	}
+	return res
+}
+
+func (t *Tensor3F32) idx(x, y, z int) int {
+	return (y*t.Width+x)*t.Depth + z
+}
+
+// Get returns the value at (x, y, z).
+func (t *Tensor3F32) Get(x, y, z int) float32 {
+	return t.Data[t.idx(x, y, z)]
+}
+
+// Set sets the value at (x, y, z).
+func (t *Tensor3F32) Set(x, y, z int, v float32) {
+	t.Data[t.idx(x, y, z)] = v
+}
+
+// Randomize randomly initializes the tensor's values,
+// mirroring Tensor3.Randomize's distribution.
+func (t *Tensor3F32) Randomize() {
+	scale := float32(1 / float64(t.Width*t.Height*t.Depth))
+	for i := range t.Data {
+		t.Data[i] = (rand.Float32()*2 - 1) * scale
+	}
+}
+
+// Crop copies the sub-tensor of size dest.Width x dest.Height
+// x dest.Depth starting at (x, y, 0) into dest.
+func (t *Tensor3F32) Crop(x, y int, dest *Tensor3F32) {
+	for dy := 0; dy < dest.Height; dy++ {
+		for dx := 0; dx < dest.Width; dx++ {
+			for dz := 0; dz < dest.Depth; dz++ {
+				dest.Set(dx, dy, dz, t.Get(x+dx, y+dy, dz))
+			}
+		}
+	}
+}
+
+// MulAdd scales src by s and adds it into t at offset (x, y).
+func (t *Tensor3F32) MulAdd(x, y int, src *Tensor3F32, s float32) {
+	for sy := 0; sy < src.Height; sy++ {
+		for sx := 0; sx < src.Width; sx++ {
+			for sz := 0; sz < src.Depth; sz++ {
+				t.Set(x+sx, y+sy, sz, t.Get(x+sx, y+sy, sz)+s*src.Get(sx, sy, sz))
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package neuralnet
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gonum/blas/blas64"
+)
+
+// tensor3Pools holds one *sync.Pool per buffer length, so that
+// repeatedly-allocated scratch tensors of a given shape (e.g.
+// the cropped receptive field in im2col) can be recycled
+// instead of hitting the allocator on every call.
+var tensor3Pools sync.Map // map[int]*sync.Pool
+
+// tensor3Outstanding tracks which Tensor3s were checked out via
+// getPooledTensor3 and haven't yet been returned with Drop.
+// TensorPoolOutstanding reports its size so tests can assert
+// that a full forward/backward cycle leaves nothing checked out.
+var tensor3Outstanding sync.Map // map[*Tensor3]struct{}
+var tensor3OutstandingCount int64
+
+// matrixOutstanding is tensor3Outstanding's blas64.General
+// counterpart: it tracks matrices checked out via
+// getPooledMatrix and not yet returned with dropMatrix, the
+// scratch buffers im2col/col2im's GEMMs (column matrix, filter
+// gradient, input-gradient column grad, ...) use internally.
+// TensorPoolOutstanding reports the combined size of both.
+var matrixOutstanding sync.Map // map[*blas64.General]struct{}
+var matrixOutstandingCount int64
+
+func tensor3PoolFor(n int) *sync.Pool {
+	poolIface, _ := tensor3Pools.LoadOrStore(n, &sync.Pool{
+		New: func() interface{} {
+			return make([]float64, n)
+		},
+	})
+	return poolIface.(*sync.Pool)
+}
+
+// getPooledTensor3 returns a zeroed Tensor3 of the given
+// shape, reusing a buffer returned by a previous Drop call
+// when one of the right size is available. Callers must call
+// Drop on the result once it's no longer needed.
+func getPooledTensor3(width, height, depth int) *Tensor3 {
+	n := width * height * depth
+	data := tensor3PoolFor(n).Get().([]float64)
+	for i := range data {
+		data[i] = 0
+	}
+	t := &Tensor3{Width: width, Height: height, Depth: depth, Data: data}
+	tensor3Outstanding.Store(t, struct{}{})
+	atomic.AddInt64(&tensor3OutstandingCount, 1)
+	return t
+}
+
+// TensorPoolOutstanding returns the number of Tensor3 and
+// blas64.General buffers currently checked out via
+// getPooledTensor3/getPooledMatrix but not yet returned via
+// Drop/dropMatrix. It exists so tests (see
+// TestConvLayerBackwardLeavesNoTensorsOutstanding) can assert
+// that a full forward/backward cycle leaves no pooled buffers
+// checked out.
+func TensorPoolOutstanding() int {
+	return int(atomic.LoadInt64(&tensor3OutstandingCount) + atomic.LoadInt64(&matrixOutstandingCount))
+}
+
+// getPooledMatrix returns a zeroed rows x cols blas64.General
+// backed by a buffer from the same length-keyed pool
+// getPooledTensor3 draws from. Callers must call dropMatrix on
+// the result once it's no longer needed.
+func getPooledMatrix(rows, cols int) *blas64.General {
+	data := tensor3PoolFor(rows * cols).Get().([]float64)
+	for i := range data {
+		data[i] = 0
+	}
+	m := &blas64.General{Rows: rows, Cols: cols, Stride: cols, Data: data}
+	matrixOutstanding.Store(m, struct{}{})
+	atomic.AddInt64(&matrixOutstandingCount, 1)
+	return m
+}
+
+// dropMatrix releases m's backing buffer so a future
+// getPooledMatrix or getPooledTensor3 call for the same length
+// can reuse it. dropMatrix is a no-op on an already-dropped matrix.
+func dropMatrix(m *blas64.General) {
+	if m.Data == nil {
+		return
+	}
+	if _, ok := matrixOutstanding.Load(m); ok {
+		matrixOutstanding.Delete(m)
+		atomic.AddInt64(&matrixOutstandingCount, -1)
+	}
+	tensor3PoolFor(len(m.Data)).Put(m.Data)
+	m.Data = nil
+}
+
+// IsNil reports whether t is unusable: either a nil pointer,
+// or a Tensor3 whose backing Data has already been Dropped.
+func (t *Tensor3) IsNil() bool {
+	return t == nil || t.Data == nil
+}
+
+// Drop releases t's backing buffer so a future getPooledTensor3
+// call for the same shape can reuse it, and clears t.Data so
+// that IsNil(t) reports true afterward. Drop is a no-op on an
+// already-nil or already-dropped tensor. Dropping a Tensor3
+// that didn't come from getPooledTensor3 (e.g. one built with
+// NewTensor3) is safe and simply donates its buffer to the
+// pool; it has no outstanding count to clear.
+func (t *Tensor3) Drop() {
+	if t.IsNil() {
+		return
+	}
+	if _, ok := tensor3Outstanding.Load(t); ok {
+		tensor3Outstanding.Delete(t)
+		atomic.AddInt64(&tensor3OutstandingCount, -1)
+	}
+	tensor3PoolFor(len(t.Data)).Put(t.Data)
+	t.Data = nil
+}
@@ -0,0 +1,71 @@
+package neuralnet
+
+import (
+	"testing"
+
+	"github.com/unixpickle/autofunc"
+)
+
+func TestTensor3PoolDropReuse(t *testing.T) {
+	before := TensorPoolOutstanding()
+
+	t1 := getPooledTensor3(4, 4, 3)
+	if t1.IsNil() {
+		t.Fatal("freshly pooled tensor reported IsNil")
+	}
+	if TensorPoolOutstanding() != before+1 {
+		t.Errorf("expected outstanding count %d, got %d", before+1, TensorPoolOutstanding())
+	}
+
+	t1.Data[0] = 42
+	t1.Drop()
+
+	if !t1.IsNil() {
+		t.Error("dropped tensor should report IsNil")
+	}
+	if TensorPoolOutstanding() != before {
+		t.Errorf("expected outstanding count to return to %d, got %d", before, TensorPoolOutstanding())
+	}
+
+	t2 := getPooledTensor3(4, 4, 3)
+	defer t2.Drop()
+	if t2.Data[0] != 0 {
+		t.Error("pooled tensor was not zeroed before reuse")
+	}
+}
+
+func TestConvLayerLeavesNoTensorsOutstanding(t *testing.T) {
+	layer := newTestConvLayer()
+	input := make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth)
+
+	before := TensorPoolOutstanding()
+	layer.convolve(input)
+	if after := TensorPoolOutstanding(); after != before {
+		t.Errorf("convolve leaked %d pooled tensors", after-before)
+	}
+}
+
+// TestConvLayerBackwardLeavesNoTensorsOutstanding is the
+// backward-pass counterpart of TestConvLayerLeavesNoTensorsOutstanding:
+// it checks that convLayerResult.PropagateGradient returns every
+// pooled buffer it checks out (via im2col/col2im) before returning,
+// not just convolve's forward pass.
+func TestConvLayerBackwardLeavesNoTensorsOutstanding(t *testing.T) {
+	layer := newTestConvLayer()
+	inVar := &autofunc.Variable{
+		Vector: make([]float64, layer.InputWidth*layer.InputHeight*layer.InputDepth),
+	}
+	for i := range inVar.Vector {
+		inVar.Vector[i] = (float64(i%5) - 2) / 2
+	}
+
+	out := layer.Apply(inVar)
+	upstream := make([]float64, len(out.Output()))
+	grad := autofunc.NewGradient(append([]*autofunc.Variable{inVar}, layer.Parameters()...))
+
+	before := TensorPoolOutstanding()
+	out.PropagateGradient(upstream, grad)
+	if after := TensorPoolOutstanding(); after != before {
+		t.Errorf("PropagateGradient leaked %d pooled tensors", after-before)
+	}
+}